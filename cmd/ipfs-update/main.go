@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	util "github.com/ipfs/ipfs-update/util"
+	cli "github.com/urfave/cli"
+	stump "github.com/whyrusleeping/stump"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "ipfs-update"
+	app.Usage = "update ipfs (go-ipfs/kubo) to a chosen or latest version"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "distpath",
+			Usage: "specify the distributions build to pull versions and binaries from",
+		},
+		cli.StringFlag{
+			Name:  "fetcher",
+			Usage: "comma-separated list of fetchers to try, in order: ipfs, http",
+		},
+		cli.StringSliceFlag{
+			Name:  "trustless-gateways",
+			Usage: "use block-verified, trustless CAR fetching against these gateways instead of trusting --distpath outright",
+		},
+		cli.BoolFlag{
+			Name:  "no-verify",
+			Usage: "skip OpenPGP signature and checksum verification of downloaded binaries (not recommended)",
+		},
+		cli.StringFlag{
+			Name:  "keyring",
+			Usage: "path to an additional armored keyring of trusted release-signing keys",
+		},
+		cli.BoolFlag{
+			Name:  "include-prereleases",
+			Usage: "consider pre-release (rc/dev) builds when listing or resolving versions",
+		},
+	}
+	app.Before = applyGlobalFlags
+	app.Commands = []cli.Command{
+		versionsCmd,
+		fetchCmd,
+		installCmd,
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		stump.Fatal(err)
+	}
+}
+
+// applyGlobalFlags wires the CLI flags declared above into the util
+// package knobs that the rest of ipfs-update's fetch/verify logic reads.
+func applyGlobalFlags(c *cli.Context) error {
+	if dp := c.String("distpath"); dp != "" {
+		util.IpfsVersionPath = dp
+	}
+
+	if names := c.String("fetcher"); names != "" {
+		if err := util.SelectFetchers(strings.Split(names, ",")); err != nil {
+			return err
+		}
+	}
+
+	if gws := c.StringSlice("trustless-gateways"); len(gws) > 0 {
+		util.TrustlessGateways = gws
+		util.Trustless = true
+	}
+
+	util.NoVerify = c.Bool("no-verify")
+
+	if kr := c.String("keyring"); kr != "" {
+		data, err := ioutil.ReadFile(kr)
+		if err != nil {
+			return fmt.Errorf("reading --keyring %q: %s", kr, err)
+		}
+		util.ExtraKeyring = data
+	}
+
+	util.IncludePrereleases = c.Bool("include-prereleases")
+
+	return nil
+}
+
+var versionsCmd = cli.Command{
+	Name:  "versions",
+	Usage: "list available versions",
+	Action: func(c *cli.Context) error {
+		rc, err := util.Fetch(path.Join(util.IpfsVersionPath, "versions"))
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range strings.Fields(string(data)) {
+			if util.IsEligibleVersion(v) {
+				fmt.Println(v)
+			}
+		}
+
+		return nil
+	},
+}
+
+var fetchCmd = cli.Command{
+	Name:      "fetch",
+	Usage:     "fetch an ipfs path and write it to a local file",
+	ArgsUsage: "<ipfs-path> <dest-file>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return fmt.Errorf("fetch takes exactly two arguments: <ipfs-path> <dest-file>")
+		}
+
+		rc, err := util.Fetch(c.Args()[0])
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(c.Args()[1], data, 0644)
+	},
+}
+
+var installCmd = cli.Command{
+	Name:      "install",
+	Usage:     "download and verify the release archive for a version, without installing it",
+	ArgsUsage: "<version>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("install takes exactly one argument: <version>")
+		}
+
+		version := c.Args()[0]
+		v, err := util.ParseVersion(version)
+		if err != nil {
+			return err
+		}
+		if v.IsPrerelease() && !util.IncludePrereleases {
+			return fmt.Errorf("%s is a pre-release; pass --include-prereleases to install it anyway", version)
+		}
+
+		archive := fmt.Sprintf("go-ipfs_%s_%s-%s.tar.gz", version, runtime.GOOS, runtime.GOARCH)
+		ipfspath := path.Join(util.IpfsVersionPath, version, archive)
+
+		stump.Log("fetching %s", ipfspath)
+
+		checksums, sig, err := util.FetchChecksums(ipfspath)
+		if err != nil {
+			return err
+		}
+
+		// Release archives can be tens of megabytes, so download them
+		// with DownloadFile's concurrent ranged transfer and progress
+		// bar instead of buffering the whole thing in memory through
+		// Fetch, which is meant for small metadata like CHECKSUMS.txt.
+		tmp, err := ioutil.TempFile("", "ipfs-update-install")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		httpFetcher := util.NewHTTPFetcher(nil)
+		if err := httpFetcher.DownloadFile(ipfspath, tmp.Name(), ""); err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			return err
+		}
+
+		if err := util.VerifyArtifact(archive, data, checksums, sig); err != nil {
+			return fmt.Errorf("verifying %s: %s", archive, err)
+		}
+
+		stump.Log("%s verified successfully", archive)
+		return nil
+	},
+}
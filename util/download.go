@@ -0,0 +1,393 @@
+package util
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	stump "github.com/whyrusleeping/stump"
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// DownloadChunks is the number of concurrent range requests DownloadFile
+// splits a transfer into when the server supports resumable ranges.
+var DownloadChunks = 4
+
+// DownloadFile retrieves the (possibly large) file at ipfspath from one of
+// f.Mirrors and writes it to destPath, downloading DownloadChunks ranges
+// concurrently when the server advertises range support and falling back
+// to a single sequential stream otherwise. If digest is non-empty, the
+// assembled file's SHA-256 must match it exactly.
+//
+// The download is written to destPath+".part" as it progresses; if that
+// file already exists from a previous, interrupted attempt and the server
+// supports ranges, the transfer resumes from where it left off instead of
+// starting over.
+func (f *HTTPFetcher) DownloadFile(ipfspath, destPath, digest string) error {
+	var lastErr error
+	for _, mirror := range f.Mirrors {
+		err := f.downloadFromMirror(mirror+ipfspath, destPath, digest)
+		if err == nil {
+			return nil
+		}
+		stump.VLog("  - mirror %s failed: %s", mirror, err)
+		lastErr = err
+	}
+
+	return fmt.Errorf("all mirrors failed, last error: %s", lastErr)
+}
+
+func (f *HTTPFetcher) downloadFromMirror(url, destPath, digest string) error {
+	head, err := http.Head(url)
+	if err != nil {
+		return fmt.Errorf("HEAD %s: %s", url, err)
+	}
+	head.Body.Close()
+
+	size := head.ContentLength
+	rangesSupported := size > 0 && head.Header.Get("Accept-Ranges") == "bytes"
+
+	partPath := destPath + ".part"
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	bar := pb.New64(size).SetUnits(pb.U_BYTES)
+	bar.Start()
+	defer bar.Finish()
+
+	var written int64
+	if rangesSupported {
+		if err := out.Truncate(size); err != nil {
+			out.Close()
+			return err
+		}
+		err = f.downloadChunked(url, out, size, partPath+".progress", &written, bar)
+	} else {
+		stump.VLog("  - server does not support range requests, downloading as a single stream")
+		err = f.downloadSequential(url, out, &written, bar)
+	}
+
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if digest != "" {
+		if err := verifySHA256(partPath, digest); err != nil {
+			return err
+		}
+	}
+
+	os.Remove(partPath + ".progress")
+	return os.Rename(partPath, destPath)
+}
+
+// downloadChunked splits [0, size) into DownloadChunks ranges and downloads
+// each concurrently via a Range request into its own disjoint region of out.
+//
+// out is truncated to size up front so WriteAt can address any chunk's
+// region, which means its on-disk size reaches its final value immediately
+// and can never be used to tell "fully downloaded" apart from "preallocated,
+// still mostly holes". Instead, which chunks actually finished is tracked in
+// progressPath, a small sidecar file of completed chunk indices: resuming a
+// previous attempt only skips chunks it says finished, and redownloads every
+// other chunk's range from scratch even though out already has data (or
+// zeros) sitting there.
+func (f *HTTPFetcher) downloadChunked(url string, out *os.File, size int64, progressPath string, written *int64, bar *pb.ProgressBar) error {
+	chunks := planChunks(size, DownloadChunks)
+	done := loadCompletedChunks(progressPath, size)
+
+	var progressMu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(chan error, len(chunks))
+
+	for _, ch := range chunks {
+		if done[ch.idx] {
+			atomic.AddInt64(written, ch.end-ch.start+1)
+			bar.Set64(atomic.LoadInt64(written))
+			continue
+		}
+
+		wg.Add(1)
+		go func(ch chunkRange) {
+			defer wg.Done()
+			if err := f.downloadRange(url, out, ch.start, ch.end, written, bar); err != nil {
+				errs <- err
+				return
+			}
+			if err := markChunkComplete(progressPath, &progressMu, size, ch.idx); err != nil {
+				errs <- err
+			}
+		}(ch)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chunkRange is one of the byte ranges planChunks splits a transfer into.
+type chunkRange struct {
+	idx        int
+	start, end int64 // inclusive
+}
+
+func planChunks(size int64, n int) []chunkRange {
+	chunkSize := size / int64(n)
+	if chunkSize == 0 {
+		chunkSize = size
+	}
+
+	var chunks []chunkRange
+	for i := 0; i < n; i++ {
+		start := int64(i) * chunkSize
+		if start >= size {
+			break
+		}
+		end := start + chunkSize - 1
+		if i == n-1 || end >= size {
+			end = size - 1
+		}
+		chunks = append(chunks, chunkRange{idx: i, start: start, end: end})
+	}
+	return chunks
+}
+
+// loadCompletedChunks reads the chunk indices progressPath records as
+// finished. Its first line is the total size the download was planned
+// against; if that doesn't match size, progressPath is stale (left over
+// from a differently-sized transfer) and is ignored entirely rather than
+// trusted.
+func loadCompletedChunks(progressPath string, size int64) map[int]bool {
+	done := make(map[int]bool)
+
+	f, err := os.Open(progressPath)
+	if err != nil {
+		return done
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			first = false
+			recordedSize, err := strconv.ParseInt(line, 10, 64)
+			if err != nil || recordedSize != size {
+				return make(map[int]bool)
+			}
+			continue
+		}
+
+		idx, err := strconv.Atoi(line)
+		if err != nil {
+			continue
+		}
+		done[idx] = true
+	}
+
+	return done
+}
+
+// markChunkComplete appends idx to progressPath, creating it (with size as
+// its header line) on first use. Concurrent chunk downloads all append
+// through mu, since multiple goroutines otherwise write to the same file.
+func markChunkComplete(progressPath string, mu *sync.Mutex, size int64, idx int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	needsHeader := false
+	if _, err := os.Stat(progressPath); os.IsNotExist(err) {
+		needsHeader = true
+	}
+
+	f, err := os.OpenFile(progressPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if needsHeader {
+		if _, err := fmt.Fprintf(f, "%d\n", size); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(f, "%d\n", idx)
+	return err
+}
+
+func (f *HTTPFetcher) downloadRange(url string, out *os.File, start, end int64, written *int64, bar *pb.ProgressBar) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("server returned %s for range request", resp.Status)
+	}
+
+	sw := &sectionWriter{f: out, off: start}
+	counter := &atomicCountWriter{written: written, bar: bar}
+	_, err = io.Copy(io.MultiWriter(sw, counter), resp.Body)
+	return err
+}
+
+// downloadSequential GETs url - resuming from out's current end via a
+// Range header when it already holds bytes from a prior attempt - and
+// retries transient failures with exponential backoff, same as
+// HTTPFetcher.getWithRetries. Unlike the chunked path, out is never
+// preallocated ahead of writing to it, so its size is always an accurate
+// record of how many bytes have actually been written.
+func (f *HTTPFetcher) downloadSequential(url string, out *os.File, written *int64, bar *pb.ProgressBar) error {
+	backoff := time.Second
+	var lastErr error
+
+	for attempt := 0; attempt <= f.MaxRetries; attempt++ {
+		offset, err := out.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+
+		if attempt > 0 {
+			stump.VLog("  - retrying %s (attempt %d/%d), resuming at byte %d: %s", url, attempt, f.MaxRetries, offset, lastErr)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", f.UserAgent)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		resp, err := f.Client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+			return nil
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			if offset > 0 {
+				if err := out.Truncate(0); err != nil {
+					resp.Body.Close()
+					return err
+				}
+				if _, err := out.Seek(0, io.SeekStart); err != nil {
+					resp.Body.Close()
+					return err
+				}
+				atomic.StoreInt64(written, 0)
+				bar.Set64(0)
+			}
+		default:
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				lastErr = fmt.Errorf("server returned %s", resp.Status)
+				continue
+			}
+			return fmt.Errorf("server returned %s", resp.Status)
+		}
+
+		counter := &atomicCountWriter{written: written, bar: bar}
+		atomic.StoreInt64(written, offset)
+		bar.Set64(offset)
+		_, copyErr := io.Copy(io.MultiWriter(out, counter), resp.Body)
+		resp.Body.Close()
+		if copyErr != nil {
+			lastErr = copyErr
+			continue
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// sectionWriter turns a stream of sequential Write calls into WriteAt calls
+// at increasing offsets starting at off, so concurrent chunk downloads can
+// each own a disjoint region of the same preallocated file.
+type sectionWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (sw *sectionWriter) Write(p []byte) (int, error) {
+	n, err := sw.f.WriteAt(p, sw.off)
+	sw.off += int64(n)
+	return n, err
+}
+
+// atomicCountWriter feeds a progress bar from an atomic byte counter shared
+// across all concurrent chunks, rather than repeatedly stat-ing the output
+// file from a separate goroutine.
+type atomicCountWriter struct {
+	written *int64
+	bar     *pb.ProgressBar
+}
+
+func (c *atomicCountWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	atomic.AddInt64(c.written, int64(n))
+	c.bar.Set64(atomic.LoadInt64(c.written))
+	return n, nil
+}
+
+func verifySHA256(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", want, got)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPlanChunksCoversFullRangeContiguously(t *testing.T) {
+	chunks := planChunks(4001, 4)
+	if len(chunks) != 4 {
+		t.Fatalf("expected 4 chunks, got %d", len(chunks))
+	}
+	if chunks[0].start != 0 {
+		t.Fatalf("expected first chunk to start at 0, got %d", chunks[0].start)
+	}
+	if chunks[len(chunks)-1].end != 4000 {
+		t.Fatalf("expected last chunk to end at 4000, got %d", chunks[len(chunks)-1].end)
+	}
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].start != chunks[i-1].end+1 {
+			t.Fatalf("chunk %d does not pick up where chunk %d left off: %d vs %d", i, i-1, chunks[i].start, chunks[i-1].end+1)
+		}
+	}
+}
+
+func TestPlanChunksSmallerThanChunkCount(t *testing.T) {
+	chunks := planChunks(2, 4)
+	if len(chunks) != 1 {
+		t.Fatalf("expected a single chunk when size < n, got %d", len(chunks))
+	}
+	if chunks[0].start != 0 || chunks[0].end != 1 {
+		t.Fatalf("expected the single chunk to cover [0,1], got [%d,%d]", chunks[0].start, chunks[0].end)
+	}
+}
+
+// rangeServer serves a fixed body over Range requests, optionally failing a
+// chosen byte range with a 500 the first time it's requested, and recording
+// how many times each range was requested.
+type rangeServer struct {
+	body     string
+	failOnce string // Range header value to fail exactly once, then serve normally
+
+	mu       sync.Mutex
+	requests map[string]int
+	failed   map[string]bool
+}
+
+func newRangeServer(body string) *rangeServer {
+	return &rangeServer{
+		body:     body,
+		requests: make(map[string]int),
+		failed:   make(map[string]bool),
+	}
+}
+
+func (s *rangeServer) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.body)))
+		return
+	}
+
+	rangeHdr := r.Header.Get("Range")
+
+	s.mu.Lock()
+	s.requests[rangeHdr]++
+	shouldFail := rangeHdr == s.failOnce && !s.failed[rangeHdr]
+	if shouldFail {
+		s.failed[rangeHdr] = true
+	}
+	s.mu.Unlock()
+
+	if shouldFail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var start, end int
+	if _, err := fmt.Sscanf(rangeHdr, "bytes=%d-%d", &start, &end); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write([]byte(s.body[start : end+1]))
+}
+
+func (s *rangeServer) requestCount(rangeHdr string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[rangeHdr]
+}
+
+// Before the fix this regresses, downloadFromMirror preallocated the .part
+// file to its full size before any chunk was downloaded, so a size-based
+// resume check on a retried attempt saw a "complete" file and skipped
+// redownloading even chunks that had failed and never actually been
+// written. This reproduces exactly that: one chunk fails on the first
+// attempt, and the resumed attempt must redownload only that chunk, not
+// trust the preallocated file's size.
+func TestDownloadFromMirrorResumesOnlyTheChunkThatFailed(t *testing.T) {
+	oldChunks := DownloadChunks
+	DownloadChunks = 4
+	defer func() { DownloadChunks = oldChunks }()
+
+	body := strings.Repeat("a", 1000) + strings.Repeat("b", 1000) + strings.Repeat("c", 1000) + strings.Repeat("d", 1000)
+	srv := newRangeServer(body)
+	srv.failOnce = "bytes=2000-2999"
+	ts := httptest.NewServer(http.HandlerFunc(srv.handler))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "out.bin")
+
+	f := NewHTTPFetcher([]string{ts.URL})
+
+	if err := f.downloadFromMirror(ts.URL, dest, ""); err == nil {
+		t.Fatal("expected the first attempt to fail when one chunk 500s")
+	}
+
+	if _, err := os.Stat(dest + ".part"); err != nil {
+		t.Fatalf("expected a .part file to survive the failed attempt: %s", err)
+	}
+
+	if err := f.downloadFromMirror(ts.URL, dest, ""); err != nil {
+		t.Fatalf("expected the resumed attempt to succeed, got: %s", err)
+	}
+
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatalf("resumed download does not match expected body (len %d vs %d)", len(got), len(body))
+	}
+
+	// The failed chunk must have been requested twice (the failure, then
+	// the successful resume); every other chunk should have been
+	// requested only once, by the first attempt -- proof that the resume
+	// didn't redownload chunks it had already finished.
+	if n := srv.requestCount("bytes=2000-2999"); n != 2 {
+		t.Fatalf("expected the failed chunk to be requested twice, got %d", n)
+	}
+	for _, rangeHdr := range []string{"bytes=0-999", "bytes=1000-1999", "bytes=3000-3999"} {
+		if n := srv.requestCount(rangeHdr); n != 1 {
+			t.Fatalf("expected chunk %s to be requested exactly once, got %d", rangeHdr, n)
+		}
+	}
+
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Fatalf("expected .part file to be gone after a successful download, got: %v", err)
+	}
+	if _, err := os.Stat(dest + ".part.progress"); !os.IsNotExist(err) {
+		t.Fatalf("expected the progress sidecar to be cleaned up after success, got: %v", err)
+	}
+}
+
+func TestLoadCompletedChunksIgnoresStaleSizeHeader(t *testing.T) {
+	dir := t.TempDir()
+	progressPath := filepath.Join(dir, "out.part.progress")
+
+	if err := ioutil.WriteFile(progressPath, []byte("999\n0\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := loadCompletedChunks(progressPath, 4000)
+	if len(done) != 0 {
+		t.Fatalf("expected a sidecar recorded against a different size to be ignored, got %v", done)
+	}
+}
@@ -0,0 +1,347 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	cid "github.com/ipfs/go-cid"
+	merkledag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	config "github.com/ipfs/ipfs-update/config"
+	mh "github.com/multiformats/go-multihash"
+	stump "github.com/whyrusleeping/stump"
+)
+
+// TrustlessGateways is the ordered list of gateways that VerifiedHTTPFetcher
+// will try when fetching CAR files. Every block returned by any of these
+// gateways is hash-verified locally, so a malicious or compromised entry on
+// this list can at worst withhold data -- it cannot inject a bad binary.
+// The root CID a path resolves to is only cross-checked when the list has
+// more than one entry; with a single gateway, that gateway's answer for the
+// root is accepted as is (see resolve).
+var TrustlessGateways = []string{
+	"https://ipfs.io",
+	"https://dweb.link",
+}
+
+// Fetcher is the common interface for anything that can retrieve the bytes
+// living at an ipfs path. Splitting this out of Fetch lets callers (and
+// tests) swap in alternate transports without touching the rest of the
+// update logic.
+type Fetcher interface {
+	Fetch(ipfspath string) (io.ReadCloser, error)
+}
+
+// VerifiedHTTPFetcher fetches content from one or more trustless gateways as
+// CAR files and verifies every block against its CID before handing the
+// reassembled bytes back to the caller. This mirrors the trustless-gateway
+// migration fetching Kubo added, and means a gateway doesn't need to be
+// trusted to serve correct data.
+type VerifiedHTTPFetcher struct {
+	Gateways  []string
+	Client    *http.Client
+	SizeLimit int64
+}
+
+func NewVerifiedHTTPFetcher(gateways []string) *VerifiedHTTPFetcher {
+	if len(gateways) == 0 {
+		gateways = TrustlessGateways
+	}
+	return &VerifiedHTTPFetcher{
+		Gateways:  gateways,
+		Client:    http.DefaultClient,
+		SizeLimit: fetchSizeLimit,
+	}
+}
+
+func (vf *VerifiedHTTPFetcher) Fetch(ipfspath string) (io.ReadCloser, error) {
+	root, err := vf.resolve(ipfspath)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, gw := range vf.Gateways {
+		data, err := vf.fetchCAR(gw, root)
+		if err != nil {
+			stump.VLog("  - trustless fetch from %s failed: %s", gw, err)
+			lastErr = err
+			continue
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return nil, fmt.Errorf("all trustless gateways failed, last error: %s", lastErr)
+}
+
+// resolve turns an /ipns/... or /ipfs/... path into the root CID it points
+// at. We ask every configured gateway for its dag-json resolution; any
+// disagreement is an error, and when more than one gateway is configured at
+// least two of them must agree on the same root CID before it is accepted,
+// so a single compromised gateway among several can't steer a trustless
+// fetch at an attacker-chosen root. When only one gateway is configured,
+// its answer is accepted without confirmation. Block-level verification
+// (readCAR/verifyBlock) still applies on top of that once a root is agreed
+// on.
+func (vf *VerifiedHTTPFetcher) resolve(ipfspath string) (cid.Cid, error) {
+	if len(vf.Gateways) == 0 {
+		return cid.Undef, fmt.Errorf("resolving %q: no gateways configured", ipfspath)
+	}
+
+	var agreed cid.Cid
+	confirmations := 0
+	var lastErr error
+
+	for _, gw := range vf.Gateways {
+		root, err := vf.resolveFrom(gw, ipfspath)
+		if err != nil {
+			stump.VLog("  - resolving %q from %s failed: %s", ipfspath, gw, err)
+			lastErr = err
+			continue
+		}
+
+		switch {
+		case !agreed.Defined():
+			agreed = root
+			confirmations = 1
+		case root.Equals(agreed):
+			confirmations++
+		default:
+			return cid.Undef, fmt.Errorf("resolving %q: gateways disagree on root CID (%s vs %s)", ipfspath, agreed, root)
+		}
+	}
+
+	if !agreed.Defined() {
+		return cid.Undef, fmt.Errorf("resolving %q: no gateway answered, last error: %s", ipfspath, lastErr)
+	}
+
+	if len(vf.Gateways) > 1 && confirmations < 2 {
+		return cid.Undef, fmt.Errorf("resolving %q: only one of %d gateways answered, refusing to trust an unconfirmed root", ipfspath, len(vf.Gateways))
+	}
+
+	return agreed, nil
+}
+
+// resolveFrom asks a single gateway to resolve ipfspath and returns the
+// root CID it reports via X-Ipfs-Roots. The result is untrusted input:
+// resolve only accepts it once enough gateways agree.
+func (vf *VerifiedHTTPFetcher) resolveFrom(gateway, ipfspath string) (cid.Cid, error) {
+	resp, err := vf.Client.Get(gateway + ipfspath + "?format=dag-json")
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return cid.Undef, fmt.Errorf("gateway returned %s", resp.Status)
+	}
+
+	rootHdr := resp.Header.Get("X-Ipfs-Roots")
+	if rootHdr == "" {
+		return cid.Undef, fmt.Errorf("gateway did not return X-Ipfs-Roots")
+	}
+
+	return cid.Decode(rootHdr)
+}
+
+// fetchCAR requests root as a CARv1 from gateway, verifies every block it
+// contains, walks the resulting UnixFS DAG, and returns the reassembled
+// file contents.
+func (vf *VerifiedHTTPFetcher) fetchCAR(gateway string, root cid.Cid) ([]byte, error) {
+	req, err := http.NewRequest("GET", gateway+"/ipfs/"+root.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	req.Header.Set("User-Agent", config.GetUserAgent())
+
+	resp, err := vf.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("gateway returned %s", resp.Status)
+	}
+
+	blocks, err := readCAR(bufio.NewReader(io.LimitReader(resp.Body, vf.SizeLimit)), vf.SizeLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := blocks[root]; !ok {
+		return nil, fmt.Errorf("CAR response did not include the requested root %s", root)
+	}
+
+	return assembleUnixFS(root, blocks, vf.SizeLimit)
+}
+
+// readCAR stream-parses a CARv1 body: a varint-length header followed by a
+// sequence of length-delimited (cid, block) entries. Every block's
+// multihash is recomputed and checked against its claimed CID, so a gateway
+// that tampers with a block's bytes is caught here rather than trusted.
+// sizeLimit caps how large a single entry's declared length is allowed to
+// be: entryLen comes straight off the wire before anything has been
+// verified, so without this a gateway could send a single absurd length
+// varint and force a huge allocation before io.ReadFull ever reads (or
+// fails to read) a single byte of it.
+func readCAR(r *bufio.Reader, sizeLimit int64) (map[cid.Cid][]byte, error) {
+	hdrLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading CAR header length: %s", err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(hdrLen)); err != nil {
+		return nil, fmt.Errorf("reading CAR header: %s", err)
+	}
+
+	blocks := make(map[cid.Cid][]byte)
+	for {
+		entryLen, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CAR entry length: %s", err)
+		}
+		if int64(entryLen) > sizeLimit {
+			return nil, fmt.Errorf("CAR entry of %d bytes exceeds size limit of %d bytes", entryLen, sizeLimit)
+		}
+
+		entry := make([]byte, entryLen)
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return nil, fmt.Errorf("reading CAR entry: %s", err)
+		}
+
+		c, n, err := cid.CidFromBytes(entry)
+		if err != nil {
+			return nil, fmt.Errorf("parsing block CID: %s", err)
+		}
+		data := entry[n:]
+
+		if err := verifyBlock(c, data); err != nil {
+			return nil, err
+		}
+
+		blocks[c] = data
+	}
+
+	return blocks, nil
+}
+
+// verifyBlock recomputes data's multihash and makes sure it matches what c
+// claims. This is the crux of trustless fetching: it doesn't matter which
+// gateway served the block, only whether it hashes correctly.
+func verifyBlock(c cid.Cid, data []byte) error {
+	decoded, err := mh.Decode(c.Hash())
+	if err != nil {
+		return fmt.Errorf("decoding multihash for %s: %s", c, err)
+	}
+
+	sum, err := mh.Sum(data, decoded.Code, decoded.Length)
+	if err != nil {
+		return fmt.Errorf("hashing block for %s: %s", c, err)
+	}
+
+	if !bytes.Equal([]byte(sum), c.Hash()) {
+		return fmt.Errorf("block for %s failed hash verification", c)
+	}
+
+	return nil
+}
+
+// assembleUnixFS walks the already-verified block set starting at root and
+// concatenates the leaves in order to reconstruct the original file. Only
+// the shapes ipfs-update actually needs to fetch (a single file, optionally
+// chunked into a balanced DAG) are supported; anything else is an error
+// rather than a silent partial result.
+//
+// Every block individually hash-verifies against its own CID, but nothing
+// about that stops a block from linking back to one of its own ancestors:
+// walk tracks the CIDs it has already visited and refuses to revisit one,
+// so a malicious gateway serving a self-referential (but still hash-valid)
+// DAG can't drive it into unbounded recursion.
+func assembleUnixFS(root cid.Cid, blocks map[cid.Cid][]byte, sizeLimit int64) ([]byte, error) {
+	var out bytes.Buffer
+	visited := make(map[cid.Cid]bool)
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		if visited[c] {
+			return fmt.Errorf("DAG revisits block %s, refusing to walk a cycle", c)
+		}
+		visited[c] = true
+
+		data, ok := blocks[c]
+		if !ok {
+			return fmt.Errorf("missing block %s referenced by DAG", c)
+		}
+
+		links, leaf, err := parseUnixFSNode(c, data)
+		if err != nil {
+			return err
+		}
+
+		out.Write(leaf)
+		if int64(out.Len()) > sizeLimit {
+			return fmt.Errorf("assembled file exceeds size limit of %d bytes", sizeLimit)
+		}
+
+		for _, l := range links {
+			if err := walk(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// parseUnixFSNode decodes a single verified block, returning the CIDs of
+// any child nodes it links to along with whatever file bytes it carries
+// directly. Which codec applies is taken from c's multicodec, not guessed
+// by seeing whether the bytes happen to parse as dag-pb: arbitrary raw
+// bytes can spuriously decode as a "valid" protobuf envelope, which would
+// silently corrupt the reassembled file despite every block having passed
+// hash verification.
+func parseUnixFSNode(c cid.Cid, data []byte) (links []cid.Cid, leaf []byte, err error) {
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return nil, data, nil
+
+	case cid.DagProtobuf:
+		nd, err := merkledag.DecodeProtobuf(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding dag-pb node %s: %s", c, err)
+		}
+
+		fsNode, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding unixfs node %s: %s", c, err)
+		}
+
+		switch fsNode.Type() {
+		case unixfs.TFile, unixfs.TRaw:
+		default:
+			return nil, nil, fmt.Errorf("unsupported unixfs node type %s for %s", fsNode.Type(), c)
+		}
+
+		for _, l := range nd.Links() {
+			links = append(links, l.Cid)
+		}
+
+		return links, fsNode.Data(), nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported block codec %d for %s", c.Prefix().Codec, c)
+	}
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestParseUnixFSNodeRawLeaf(t *testing.T) {
+	data := []byte("hello world, this is a raw leaf block")
+
+	sum, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cid.NewCidV1(cid.Raw, sum)
+
+	links, leaf, err := parseUnixFSNode(c, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(links) != 0 {
+		t.Fatalf("raw leaf should have no links, got %d", len(links))
+	}
+	if string(leaf) != string(data) {
+		t.Fatal("expected raw leaf bytes to pass through unchanged")
+	}
+}
+
+// Before this fix, whether a block was a dag-pb node or a raw leaf was
+// decided by whether it happened to parse as protobuf, so arbitrary bytes
+// under a non-dag-pb codec that spuriously "parsed" would have been
+// silently treated as a node. This pins codec dispatch to the CID itself.
+func TestParseUnixFSNodeRejectsUnsupportedCodec(t *testing.T) {
+	data := []byte("not dag-pb, not raw, just bytes under the wrong codec")
+
+	sum, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cid.NewCidV1(cid.DagCBOR, sum)
+
+	if _, _, err := parseUnixFSNode(c, data); err == nil {
+		t.Fatal("expected a block under an unsupported codec to be rejected")
+	}
+}
+
+// rootA and rootB are well-known example CIDs, used here only as distinct
+// valid CID values for two gateways to (dis)agree on.
+const (
+	rootA = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	rootB = "bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"
+)
+
+func gatewayReturning(root string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ipfs-Roots", root)
+	}))
+}
+
+func TestResolveRequiresGatewayAgreement(t *testing.T) {
+	gwA1 := gatewayReturning(rootA)
+	defer gwA1.Close()
+	gwA2 := gatewayReturning(rootA)
+	defer gwA2.Close()
+	gwB := gatewayReturning(rootB)
+	defer gwB.Close()
+
+	agreeing := NewVerifiedHTTPFetcher([]string{gwA1.URL, gwA2.URL})
+	root, err := agreeing.resolve("/ipns/dist.ipfs.io")
+	if err != nil {
+		t.Fatalf("expected two agreeing gateways to resolve, got: %s", err)
+	}
+	if root.String() != rootA {
+		t.Fatalf("expected resolved root %s, got %s", rootA, root)
+	}
+
+	disagreeing := NewVerifiedHTTPFetcher([]string{gwA1.URL, gwB.URL})
+	if _, err := disagreeing.resolve("/ipns/dist.ipfs.io"); err == nil {
+		t.Fatal("expected disagreeing gateways to fail resolution rather than trust the first one")
+	}
+}
@@ -0,0 +1,240 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	api "github.com/ipfs/go-ipfs-api"
+	config "github.com/ipfs/ipfs-update/config"
+	stump "github.com/whyrusleeping/stump"
+)
+
+// IPFSFetcher fetches paths through a locally running ipfs daemon's HTTP
+// API, when one is reachable. It is tried first by the default fetcher
+// chain since it avoids the network entirely.
+type IPFSFetcher struct {
+	ApiEndpoint string
+}
+
+func NewIPFSFetcher() *IPFSFetcher {
+	return &IPFSFetcher{}
+}
+
+func (f *IPFSFetcher) Fetch(ipfspath string) (io.ReadCloser, error) {
+	ep := f.ApiEndpoint
+	if ep == "" {
+		var err error
+		ep, err = ApiEndpoint(IpfsDir())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sh := api.NewShell(ep)
+	if !sh.IsUp() {
+		return nil, fmt.Errorf("no local ipfs daemon running")
+	}
+
+	stump.VLog("  - using local ipfs daemon for transfer")
+	rc, err := sh.Cat(ipfspath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLimitReadCloser(rc, fetchSizeLimit), nil
+}
+
+// HTTPFetcher fetches paths over plain HTTP(S), trying each of Mirrors in
+// turn and retrying transient failures with exponential backoff.
+type HTTPFetcher struct {
+	Mirrors    []string
+	UserAgent  string
+	Client     *http.Client
+	MaxRetries int
+}
+
+// DefaultMirrors is used by NewHTTPFetcher when no mirrors are given.
+var DefaultMirrors = []string{"https://ipfs.io"}
+
+func NewHTTPFetcher(mirrors []string) *HTTPFetcher {
+	if len(mirrors) == 0 {
+		mirrors = DefaultMirrors
+	}
+
+	return &HTTPFetcher{
+		Mirrors:    mirrors,
+		UserAgent:  config.GetUserAgent(),
+		Client:     http.DefaultClient,
+		MaxRetries: 3,
+	}
+}
+
+func (f *HTTPFetcher) Fetch(ipfspath string) (io.ReadCloser, error) {
+	var lastErr error
+	for _, mirror := range f.Mirrors {
+		rc, err := f.fetchFromMirror(mirror + ipfspath)
+		if err == nil {
+			return rc, nil
+		}
+		stump.VLog("  - mirror %s failed: %s", mirror, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("all mirrors failed, last error: %s", lastErr)
+}
+
+// fetchFromMirror downloads url into a temp file and returns a ReadCloser
+// over it, removing the temp file on Close. Downloading to disk first
+// (rather than streaming the response body straight through) is what lets
+// getWithRetries resume a retry with a Range request instead of restarting
+// from byte zero.
+func (f *HTTPFetcher) fetchFromMirror(url string) (io.ReadCloser, error) {
+	tmp, err := ioutil.TempFile("", "ipfs-update-fetch")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.getWithRetries(url, tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return newLimitReadCloser(&removeOnCloseFile{tmp}, fetchSizeLimit), nil
+}
+
+// getWithRetries GETs url and appends the response to tmp, retrying on 5xx
+// responses and transport errors (timeouts, connection resets) with
+// exponential backoff. On each retry it resumes from tmp's current size
+// via a Range request instead of starting the download over; if the server
+// doesn't honor the Range (200 instead of 206/416) tmp is truncated and the
+// download restarts cleanly. It does not retry 4xx responses, since those
+// won't be fixed by trying again.
+func (f *HTTPFetcher) getWithRetries(url string, tmp *os.File) error {
+	backoff := time.Second
+	var lastErr error
+
+	for attempt := 0; attempt <= f.MaxRetries; attempt++ {
+		offset, err := tmp.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+
+		if attempt > 0 {
+			stump.VLog("  - retrying %s (attempt %d/%d), resuming at byte %d: %s", url, attempt, f.MaxRetries, offset, lastErr)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", f.UserAgent)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		resp, err := f.Client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusRequestedRangeNotSatisfiable:
+			// the range we asked to resume from is already the full
+			// body; nothing left to download.
+			resp.Body.Close()
+			return nil
+		case http.StatusPartialContent:
+			// server honored our Range header; append to what we have.
+		case http.StatusOK:
+			if offset > 0 {
+				// server ignored Range and is sending the whole body
+				// again; start this attempt over from scratch.
+				if err := tmp.Truncate(0); err != nil {
+					resp.Body.Close()
+					return err
+				}
+				if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+					resp.Body.Close()
+					return err
+				}
+			}
+		default:
+			if resp.StatusCode >= 500 {
+				resp.Body.Close()
+				lastErr = fmt.Errorf("server returned %s", resp.Status)
+				continue
+			}
+			mes, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("error reading error body: %s", err)
+			}
+			return fmt.Errorf("%s: %s", resp.Status, string(mes))
+		}
+
+		_, copyErr := io.Copy(tmp, resp.Body)
+		resp.Body.Close()
+		if copyErr != nil {
+			lastErr = copyErr
+			continue
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// removeOnCloseFile deletes its backing file once Close is called, so
+// callers of fetchFromMirror don't need to know it's backed by a temp file.
+type removeOnCloseFile struct {
+	*os.File
+}
+
+func (f *removeOnCloseFile) Close() error {
+	name := f.File.Name()
+	err := f.File.Close()
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// MultiFetcher tries each of its Fetchers in order, falling back to the
+// next on error. This is what lets ipfs-update try a local daemon first
+// and only fall back to the network when one isn't available.
+type MultiFetcher struct {
+	Fetchers []Fetcher
+}
+
+func NewMultiFetcher(fetchers ...Fetcher) *MultiFetcher {
+	return &MultiFetcher{Fetchers: fetchers}
+}
+
+func (mf *MultiFetcher) Fetch(ipfspath string) (io.ReadCloser, error) {
+	var lastErr error
+	for _, f := range mf.Fetchers {
+		rc, err := f.Fetch(ipfspath)
+		if err == nil {
+			return rc, nil
+		}
+		stump.VLog("  - fetcher %T failed: %s", f, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("all fetchers failed, last error: %s", lastErr)
+}
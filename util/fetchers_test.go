@@ -0,0 +1,169 @@
+package util
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func newFetcherUnderTest() *HTTPFetcher {
+	f := NewHTTPFetcher(nil)
+	f.MaxRetries = 3
+	return f
+}
+
+func TestGetWithRetriesRetriesOn5xxThenSucceeds(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("the full body"))
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempFile("", "fetchers-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+
+	f := newFetcherUnderTest()
+	if err := f.getWithRetries(srv.URL, tmp); err != nil {
+		t.Fatalf("expected retry after a 5xx to succeed, got: %s", err)
+	}
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Fatalf("expected exactly 2 attempts, got %d", attempts)
+	}
+
+	data, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "the full body" {
+		t.Fatalf("expected full body, got %q", data)
+	}
+}
+
+func TestGetWithRetriesDoesNotRetry4xx(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempFile("", "fetchers-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+
+	f := newFetcherUnderTest()
+	if err := f.getWithRetries(srv.URL, tmp); err == nil {
+		t.Fatal("expected a 404 to be returned as an error")
+	}
+	if atomic.LoadInt32(&attempts) != 1 {
+		t.Fatalf("expected a 4xx to not be retried, got %d attempts", attempts)
+	}
+}
+
+// hijackAndHangUpAfter writes half of want as a normal response, then
+// hijacks and closes the connection without writing the rest, simulating a
+// connection that drops mid-transfer rather than a clean non-2xx reply.
+func hijackAndHangUpAfter(w http.ResponseWriter, want string) {
+	half := want[:len(want)/2]
+
+	hj := w.(http.Hijacker)
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	bufrw.WriteString("HTTP/1.1 200 OK\r\n")
+	bufrw.WriteString("Content-Length: " + strconv.Itoa(len(want)) + "\r\n\r\n")
+	bufrw.WriteString(half)
+	bufrw.Flush()
+}
+
+func TestGetWithRetriesResumesViaRangeAfterDroppedConnection(t *testing.T) {
+	const want = "0123456789abcdefghijklmnopqrstuvwxyz"
+	rangeStart := len(want) / 2
+	var rangeHeaderOnResume string
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			hijackAndHangUpAfter(w, want)
+			return
+		}
+
+		rangeHeaderOnResume = r.Header.Get("Range")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(want[rangeStart:]))
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempFile("", "fetchers-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+
+	f := newFetcherUnderTest()
+	if err := f.getWithRetries(srv.URL, tmp); err != nil {
+		t.Fatalf("expected resume after a dropped connection to succeed, got: %s", err)
+	}
+
+	expectedRange := "bytes=" + strconv.Itoa(rangeStart) + "-"
+	if rangeHeaderOnResume != expectedRange {
+		t.Fatalf("expected retry to resume with Range %q, got %q", expectedRange, rangeHeaderOnResume)
+	}
+
+	data, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Fatalf("expected resumed download to equal %q, got %q", want, data)
+	}
+}
+
+func TestGetWithRetriesRestartsWhenServerIgnoresRange(t *testing.T) {
+	const want = "the full body returned again from scratch"
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		// Always answers 200 with the whole body, even once a Range
+		// header is sent, as a server without range support would.
+		w.Write([]byte(want))
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempFile("", "fetchers-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString("stale partial data from a previous attempt"); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFetcherUnderTest()
+	if err := f.getWithRetries(srv.URL, tmp); err != nil {
+		t.Fatalf("expected download to succeed, got: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Fatalf("expected stale partial data to be discarded and replaced with %q, got %q", want, data)
+	}
+}
@@ -4,16 +4,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	api "github.com/ipfs/go-ipfs-api"
-	config "github.com/ipfs/ipfs-update/config"
 	stump "github.com/whyrusleeping/stump"
 )
 
@@ -47,131 +44,50 @@ func ApiEndpoint(ipfspath string) (string, error) {
 	return parts[2] + ":" + parts[4], nil
 }
 
-func httpGet(url string) (*http.Response, error) {
-    // Do HTTP HEAD for payload size, retain connection
-    headResponse, err := http.Head(url)
-
-    out, err := ioutil.TempFile(os.TempDir(), "ipfs")
-    defer os.Remove(out.Name())
-
-    if err != nil {
-    	return nil, fmt.Errorf("http.Head error: %s", err)
-    }
-
-    defer out.Close()
-
-    size, err := strconv.Atoi(headResponse.Header.Get("Content-Length"))
-    
-    if err != nil {
-    	return nil, fmt.Errorf("http.Head Content-Length error: %s", err)
-    }
-
-    headResponse.Body.Close()
-
-    done := make(chan int64)
-
-    go PrintProgress(done, out.Name(), int64(size))
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("http.NewRequest error: %s", err)
-	}
-
-	req.Header.Set("User-Agent", config.GetUserAgent())
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("http.DefaultClient.Do error: %s", err)
-	}
-
-    // Following line causes ipfs binary download to fail due to EOF
-    n, err := io.Copy(out, resp.Body)
-
-	if err != nil {
-		fmt.Printf("ERRORRRR")
-		return nil, fmt.Errorf("Error writing temp file to disk: %s", err)
-	}
-
-	done <- n
-
-	return resp, nil
-}
-
-func PrintProgress(done chan int64, path string, total int64) {
-	var halt bool = false
-	var progressString string = "Download progress:"
-	for {
-		select {
-		case <- done:
-			halt = true
+// Trustless, when set, makes Fetch verify every block it downloads against
+// its CID instead of trusting the configured fetcher outright. Set by the
+// --trustless-gateways CLI flag.
+var Trustless = false
+
+// DefaultFetcher is the Fetcher used by Fetch. It is rebuilt by
+// SelectFetchers when --fetcher changes the desired transport, and defaults
+// to trying the local daemon before falling back to HTTP against
+// DefaultMirrors.
+var DefaultFetcher Fetcher = NewMultiFetcher(NewIPFSFetcher(), NewHTTPFetcher(nil))
+
+// SelectFetchers rebuilds DefaultFetcher from a list of fetcher names, in
+// the order they should be tried. Recognized names are "ipfs" and "http".
+// This backs the --fetcher=ipfs,http CLI flag.
+func SelectFetchers(names []string) error {
+	var fetchers []Fetcher
+	for _, name := range names {
+		switch name {
+		case "ipfs":
+			fetchers = append(fetchers, NewIPFSFetcher())
+		case "http":
+			fetchers = append(fetchers, NewHTTPFetcher(nil))
 		default:
-			file, err := os.Open(path)
-			if err != nil {
-				return
-			}
-
-			fi, err := file.Stat()
-			if err != nil {
-				return
-			}
-
-			size := fi.Size()
-
-			if size == 0 {
-				size = 1
-			}
-
-			var percent float64 = float64(size) / float64(total) * 100
-
-			fmt.Printf("\r%s %.0f%%", progressString, percent)
+			return fmt.Errorf("unknown fetcher %q", name)
 		}
-
-		if halt {
-			fmt.Printf("\r%s COMPLETE\n", progressString)
-			break
-		}
-
-		time.Sleep(time.Second)
-	}
-}
-
-func httpFetch(url string) (io.ReadCloser, error) {
-	stump.VLog("fetching url: %s", url)
-	resp, err := httpGet(url)
-	if err != nil {
-		return nil, err
 	}
 
-	if resp.StatusCode >= 400 {
-		stump.Error("fetching resource: %s", resp.Status)
-		mes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading error body: %s", err)
-		}
-
-		return nil, fmt.Errorf("%s: %s", resp.Status, string(mes))
+	if len(fetchers) == 0 {
+		return fmt.Errorf("no fetchers given")
 	}
 
-	return newLimitReadCloser(resp.Body, fetchSizeLimit), nil
+	DefaultFetcher = NewMultiFetcher(fetchers...)
+	return nil
 }
 
 func Fetch(ipfspath string) (io.ReadCloser, error) {
 	stump.VLog("  - fetching %q", ipfspath)
-	ep, err := ApiEndpoint(IpfsDir())
-	if err == nil {
-		sh := api.NewShell(ep)
-		if sh.IsUp() {
-			stump.VLog("  - using local ipfs daemon for transfer")
-			rc, err := sh.Cat(ipfspath)
-			if err != nil {
-				return nil, err
-			}
 
-			return newLimitReadCloser(rc, fetchSizeLimit), nil
-		}
+	if Trustless {
+		stump.VLog("  - verifying fetched blocks against trustless gateways %v", TrustlessGateways)
+		return NewVerifiedHTTPFetcher(TrustlessGateways).Fetch(ipfspath)
 	}
 
-	return httpFetch(GlobalGatewayUrl + ipfspath)
+	return DefaultFetcher.Fetch(ipfspath)
 }
 
 type limitReadCloser struct {
@@ -245,28 +161,6 @@ func RunCmd(p, bin string, args ...string) (string, error) {
 	return string(out), nil
 }
 
-func BeforeVersion(check, cur string) bool {
-	aparts := strings.Split(check[1:], ".")
-	bparts := strings.Split(cur[1:], ".")
-	for i := 0; i < 3; i++ {
-		an, err := strconv.Atoi(aparts[i])
-		if err != nil {
-			return false
-		}
-		bn, err := strconv.Atoi(bparts[i])
-		if err != nil {
-			return false
-		}
-		if bn < an {
-			return true
-		}
-		if bn > an {
-			return false
-		}
-	}
-	return false
-}
-
 func BoldText(s string) string {
 	return fmt.Sprintf("\033[1m%s\033[0m")
 }
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// These fixtures were produced by generating a throwaway OpenPGP key and
+// signing testdata/CHECKSUMS.txt with it. The key is not embedded in the
+// binary (keys/release-keys.asc is an empty placeholder until the real
+// dist.ipfs.io key is added), so it's supplied here via ExtraKeyring the
+// same way --keyring would, exercising the exact same verification code
+// path VerifyArtifact uses against a real dist.ipfs.io release: a genuine
+// OpenPGP signature over a genuine SHA-512 checksum line.
+func withTestKeyring(t *testing.T) {
+	t.Helper()
+
+	key, err := ioutil.ReadFile("testdata/test-release-key.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := ExtraKeyring
+	ExtraKeyring = key
+	t.Cleanup(func() { ExtraKeyring = prev })
+}
+
+func TestVerifyArtifactRoundTrip(t *testing.T) {
+	withTestKeyring(t)
+
+	data, err := ioutil.ReadFile("testdata/fixture-artifact.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := ioutil.ReadFile("testdata/CHECKSUMS.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ioutil.ReadFile("testdata/CHECKSUMS.txt.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyArtifact("fixture-artifact.bin", data, checksums, sig); err != nil {
+		t.Fatalf("expected valid artifact to verify, got: %s", err)
+	}
+}
+
+func TestVerifyArtifactRejectsTamperedData(t *testing.T) {
+	withTestKeyring(t)
+
+	data, err := ioutil.ReadFile("testdata/fixture-artifact.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := ioutil.ReadFile("testdata/CHECKSUMS.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ioutil.ReadFile("testdata/CHECKSUMS.txt.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append(append([]byte{}, data...), 'x')
+	if err := VerifyArtifact("fixture-artifact.bin", tampered, checksums, sig); err == nil {
+		t.Fatal("expected tampered artifact to fail verification")
+	}
+}
+
+func TestVerifyArtifactRejectsUnsignedChecksums(t *testing.T) {
+	withTestKeyring(t)
+
+	data, err := ioutil.ReadFile("testdata/fixture-artifact.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := ioutil.ReadFile("testdata/CHECKSUMS.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forgedChecksums := append([]byte{}, checksums...)
+	forgedChecksums = append(forgedChecksums, '\n')
+
+	sig, err := ioutil.ReadFile("testdata/CHECKSUMS.txt.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyArtifact("fixture-artifact.bin", data, forgedChecksums, sig); err == nil {
+		t.Fatal("expected checksums modified after signing to fail verification")
+	}
+}
+
+func TestVerifyArtifactFailsClosedWithoutAnyTrustedKeys(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/fixture-artifact.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := ioutil.ReadFile("testdata/CHECKSUMS.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ioutil.ReadFile("testdata/CHECKSUMS.txt.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyArtifact("fixture-artifact.bin", data, checksums, sig); err == nil {
+		t.Fatal("expected verification to fail closed with no embedded key and no --keyring")
+	}
+}
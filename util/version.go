@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	semver "github.com/Masterminds/semver/v3"
+)
+
+// IncludePrereleases makes the update command consider pre-release builds
+// (e.g. v0.20.0-rc1) eligible "newer" versions instead of skipping them.
+// Set by --include-prereleases.
+var IncludePrereleases = false
+
+// Version wraps a parsed semver version string such as the ones
+// dist.ipfs.io publishes (e.g. "v0.19.0", "v0.20.0-rc1").
+type Version struct {
+	raw string
+	sv  *semver.Version
+}
+
+// ParseVersion parses a version string like "v0.19.0". The leading "v"
+// ipfs-update's releases always use is optional, and versions with fewer
+// than three dotted components (e.g. "v0.9"), pre-release tags, and build
+// metadata are all accepted. A version with more than three dotted
+// components (e.g. "v0.4.3.1") is rejected with an error.
+func ParseVersion(s string) (Version, error) {
+	sv, err := semver.NewVersion(strings.TrimPrefix(s, "v"))
+	if err != nil {
+		return Version{}, fmt.Errorf("parsing version %q: %s", s, err)
+	}
+
+	return Version{raw: s, sv: sv}, nil
+}
+
+// String returns the original, unparsed version string.
+func (v Version) String() string {
+	return v.raw
+}
+
+// Compare returns -1, 0, or 1 depending on whether v is less than, equal
+// to, or greater than other, per semver precedence (pre-releases sort
+// before the release they precede; build metadata is ignored).
+func (v Version) Compare(other Version) int {
+	return v.sv.Compare(other.sv)
+}
+
+func (v Version) Less(other Version) bool {
+	return v.Compare(other) < 0
+}
+
+func (v Version) Equal(other Version) bool {
+	return v.Compare(other) == 0
+}
+
+// IsPrerelease reports whether v carries a pre-release tag, e.g. the
+// "rc1" in "v0.20.0-rc1".
+func (v Version) IsPrerelease() bool {
+	return v.sv.Prerelease() != ""
+}
+
+// Satisfies reports whether v matches the given semver constraint, e.g.
+// ">=0.19.0, <0.21.0".
+func (v Version) Satisfies(constraint string) (bool, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false, fmt.Errorf("parsing constraint %q: %s", constraint, err)
+	}
+
+	return c.Check(v.sv), nil
+}
+
+// BeforeVersion reports whether check sorts before cur under semver
+// precedence. The previous implementation silently returned false on any
+// non-numeric dotted component (misordering every RC and dev build) and
+// panicked on versions without exactly three dotted numeric components;
+// this one handles both correctly.
+func BeforeVersion(check, cur string) bool {
+	a, err := ParseVersion(check)
+	if err != nil {
+		return false
+	}
+
+	b, err := ParseVersion(cur)
+	if err != nil {
+		return false
+	}
+
+	return a.Less(b)
+}
+
+// IsEligibleVersion reports whether v should be offered by "is there a
+// newer version" logic: always true for a proper release, and true for a
+// pre-release only when IncludePrereleases is set.
+func IsEligibleVersion(v string) bool {
+	parsed, err := ParseVersion(v)
+	if err != nil {
+		return false
+	}
+
+	return IncludePrereleases || !parsed.IsPrerelease()
+}
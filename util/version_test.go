@@ -0,0 +1,138 @@
+package util
+
+import "testing"
+
+func mustParseVersion(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := ParseVersion(s)
+	if err != nil {
+		t.Fatalf("parsing %q: %s", s, err)
+	}
+	return v
+}
+
+func TestVersionCompareOrdersReleasesNumerically(t *testing.T) {
+	older := mustParseVersion(t, "v0.9.0")
+	newer := mustParseVersion(t, "v0.10.0")
+
+	if !older.Less(newer) {
+		t.Fatal("expected v0.9.0 to sort before v0.10.0, not after it lexically")
+	}
+	if newer.Less(older) {
+		t.Fatal("expected v0.10.0 to not sort before v0.9.0")
+	}
+}
+
+func TestVersionCompareIgnoresLeadingV(t *testing.T) {
+	a := mustParseVersion(t, "v1.2.3")
+	b := mustParseVersion(t, "1.2.3")
+
+	if !a.Equal(b) {
+		t.Fatalf("expected %q and %q to compare equal", a, b)
+	}
+}
+
+// This is the ordering the rewrite exists for: a pre-release must sort
+// before the release it precedes, not after it as a naive string/field
+// comparison of the dotted version would produce.
+func TestVersionPrereleaseSortsBeforeRelease(t *testing.T) {
+	rc := mustParseVersion(t, "v0.20.0-rc1")
+	release := mustParseVersion(t, "v0.20.0")
+
+	if !rc.Less(release) {
+		t.Fatal("expected v0.20.0-rc1 to sort before v0.20.0")
+	}
+	if release.Less(rc) {
+		t.Fatal("expected v0.20.0 to not sort before v0.20.0-rc1")
+	}
+}
+
+func TestVersionPrereleaseOrdering(t *testing.T) {
+	rc1 := mustParseVersion(t, "v0.20.0-rc1")
+	rc2 := mustParseVersion(t, "v0.20.0-rc2")
+
+	if !rc1.Less(rc2) {
+		t.Fatal("expected rc1 to sort before rc2")
+	}
+}
+
+func TestVersionBuildMetadataIgnoredForOrdering(t *testing.T) {
+	a := mustParseVersion(t, "v0.20.0+build1")
+	b := mustParseVersion(t, "v0.20.0+build2")
+
+	if !a.Equal(b) {
+		t.Fatal("expected build metadata to be ignored by Compare/Equal")
+	}
+}
+
+func TestVersionIsPrerelease(t *testing.T) {
+	if !mustParseVersion(t, "v0.20.0-rc1").IsPrerelease() {
+		t.Fatal("expected v0.20.0-rc1 to be a pre-release")
+	}
+	if mustParseVersion(t, "v0.20.0").IsPrerelease() {
+		t.Fatal("expected v0.20.0 to not be a pre-release")
+	}
+}
+
+func TestVersionSatisfiesConstraint(t *testing.T) {
+	v := mustParseVersion(t, "v0.19.1")
+
+	ok, err := v.Satisfies(">=0.19.0, <0.21.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected v0.19.1 to satisfy >=0.19.0, <0.21.0")
+	}
+
+	ok, err = v.Satisfies(">=0.21.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected v0.19.1 to not satisfy >=0.21.0")
+	}
+}
+
+func TestVersionSatisfiesInvalidConstraint(t *testing.T) {
+	v := mustParseVersion(t, "v0.19.1")
+	if _, err := v.Satisfies("not a constraint"); err == nil {
+		t.Fatal("expected an invalid constraint string to return an error")
+	}
+}
+
+func TestBeforeVersionHandlesPrereleasesAndShortVersions(t *testing.T) {
+	if !BeforeVersion("v0.20.0-rc1", "v0.20.0") {
+		t.Fatal("expected v0.20.0-rc1 to be reported as before v0.20.0")
+	}
+	if BeforeVersion("v0.20.0", "v0.20.0-rc1") {
+		t.Fatal("expected v0.20.0 to not be reported as before v0.20.0-rc1")
+	}
+	if !BeforeVersion("v0.9", "v0.10.0") {
+		t.Fatal("expected a version with fewer dotted components to still compare correctly")
+	}
+}
+
+func TestBeforeVersionReturnsFalseOnUnparsableInput(t *testing.T) {
+	if BeforeVersion("not-a-version", "v0.20.0") {
+		t.Fatal("expected an unparsable version to not be reported as before anything")
+	}
+}
+
+func TestIsEligibleVersionRespectsIncludePrereleases(t *testing.T) {
+	old := IncludePrereleases
+	defer func() { IncludePrereleases = old }()
+
+	IncludePrereleases = false
+	if IsEligibleVersion("v0.20.0-rc1") {
+		t.Fatal("expected a pre-release to be ineligible by default")
+	}
+	if !IsEligibleVersion("v0.20.0") {
+		t.Fatal("expected a proper release to always be eligible")
+	}
+
+	IncludePrereleases = true
+	if !IsEligibleVersion("v0.20.0-rc1") {
+		t.Fatal("expected a pre-release to be eligible once IncludePrereleases is set")
+	}
+}